@@ -0,0 +1,275 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/canonical/pebble/internals/logger"
+	"github.com/canonical/pebble/internals/plan"
+)
+
+func v1GetPlan(c *Command, r *http.Request, user *UserState) Response {
+	query := r.URL.Query()
+	format := query.Get("format")
+	switch format {
+	case "yaml":
+		planYAML, err := renderPlanYAML(c.d.overlord.PlanManager().Plan(), user)
+		if err != nil {
+			return BadRequest("cannot fetch plan: %v", err)
+		}
+		return SyncResponse(string(planYAML))
+	case "diff":
+		return v1GetPlanDiff(c, query, user)
+	default:
+		return BadRequest("invalid format %q", format)
+	}
+}
+
+// v1GetPlanDiff implements "GET /v1/plan?format=diff&against=<label>": it
+// diffs the current plan against the plan as it stood with layers only up
+// to and including against, so operators can audit what a later layer (or
+// layers) changed.
+func v1GetPlanDiff(c *Command, query url.Values, user *UserState) Response {
+	against := query.Get("against")
+	if against == "" {
+		return BadRequest(`"against" must be set when format is "diff"`)
+	}
+
+	planMgr := c.d.overlord.PlanManager()
+	before, err := planMgr.PlanAsOf(against)
+	if err != nil {
+		return BadRequest("%v", err)
+	}
+	after := planMgr.Plan()
+
+	return SyncResponse(diffPlans(before, after, user))
+}
+
+// layersPayload is the JSON body accepted by v1PostLayers. Which fields are
+// required depends on Action: "add" and "replace" need Label, Format and
+// Layer; "remove" needs only Label; "reorder" needs only Labels.
+type layersPayload struct {
+	Action  string `json:"action"`
+	Combine bool   `json:"combine"`
+	Label   string `json:"label"`
+	Format  string `json:"format"`
+	Layer   string `json:"layer"`
+
+	// Labels is the full, new ordering of layer labels, used by the
+	// "reorder" action.
+	Labels []string `json:"labels"`
+
+	// DryRun asks the daemon to run every check it would normally run
+	// (YAML parsing, plan validation, policy evaluation) without actually
+	// committing the resulting plan. It lets callers pre-flight a layer
+	// push, for example from CI, before it takes effect.
+	DryRun bool `json:"dry-run"`
+}
+
+func v1PostLayers(c *Command, r *http.Request, user *UserState) Response {
+	var payload layersPayload
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		return BadRequest("cannot decode request body: %v", err)
+	}
+
+	switch payload.Action {
+	case "add":
+		return v1AddLayer(c, user, payload)
+	case "remove":
+		return v1RemoveLayer(c, user, payload)
+	case "replace":
+		return v1ReplaceLayer(c, user, payload)
+	case "reorder":
+		return v1ReorderLayers(c, user, payload)
+	default:
+		return BadRequest("invalid action %q", payload.Action)
+	}
+}
+
+func v1AddLayer(c *Command, user *UserState, payload layersPayload) Response {
+	if payload.Label == "" {
+		return BadRequest("label must be set")
+	}
+	if payload.Format != "yaml" {
+		return BadRequest("invalid format %q", payload.Format)
+	}
+
+	layer, err := plan.ParseLayer([]byte(payload.Layer), payload.Label)
+	if err != nil {
+		return BadRequest("cannot parse layer YAML: %v", err)
+	}
+
+	planMgr := c.d.overlord.PlanManager()
+	combined, err := planMgr.PreviewLayer(layer, payload.Combine)
+	if err != nil {
+		return BadRequest("%v", err)
+	}
+
+	ctx := plan.WithCombine(context.Background(), payload.Combine)
+	if errs := plan.ValidateLayer(ctx, layer, combined); len(errs) > 0 {
+		return validationBadRequest(errs)
+	}
+
+	if violations, ok := evaluateLayerPolicy(c, user, payload.Label, combined); !ok {
+		return violations
+	}
+
+	if payload.DryRun {
+		return SyncResponse(diffPlans(planMgr.Plan(), combined, user))
+	}
+
+	if err := planMgr.CommitLayer(layer, payload.Combine); err != nil {
+		return BadRequest("%v", err)
+	}
+
+	logger.Noticef("authz_admin:%s,add_layer Adding layer %s", accessTag(user), payload.Label)
+
+	return SyncResponse(true)
+}
+
+func v1RemoveLayer(c *Command, user *UserState, payload layersPayload) Response {
+	if payload.Label == "" {
+		return BadRequest("label must be set")
+	}
+
+	planMgr := c.d.overlord.PlanManager()
+	combined, err := planMgr.PreviewRemoveLayer(payload.Label)
+	if err != nil {
+		return BadRequest("%v", err)
+	}
+
+	if errs := plan.ValidatePlan(combined); len(errs) > 0 {
+		return validationBadRequest(errs)
+	}
+
+	if payload.DryRun {
+		return SyncResponse(diffPlans(planMgr.Plan(), combined, user))
+	}
+
+	if err := planMgr.CommitRemoveLayer(payload.Label); err != nil {
+		return BadRequest("%v", err)
+	}
+
+	logger.Noticef("authz_admin:%s,remove_layer Removing layer %s", accessTag(user), payload.Label)
+
+	return SyncResponse(true)
+}
+
+func v1ReplaceLayer(c *Command, user *UserState, payload layersPayload) Response {
+	if payload.Label == "" {
+		return BadRequest("label must be set")
+	}
+	if payload.Format != "yaml" {
+		return BadRequest("invalid format %q", payload.Format)
+	}
+
+	layer, err := plan.ParseLayer([]byte(payload.Layer), payload.Label)
+	if err != nil {
+		return BadRequest("cannot parse layer YAML: %v", err)
+	}
+
+	planMgr := c.d.overlord.PlanManager()
+	combined, err := planMgr.PreviewReplaceLayer(layer)
+	if err != nil {
+		return BadRequest("%v", err)
+	}
+
+	if errs := plan.ValidateLayer(context.Background(), layer, combined); len(errs) > 0 {
+		return validationBadRequest(errs)
+	}
+
+	if violations, ok := evaluateLayerPolicy(c, user, payload.Label, combined); !ok {
+		return violations
+	}
+
+	if payload.DryRun {
+		return SyncResponse(diffPlans(planMgr.Plan(), combined, user))
+	}
+
+	if err := planMgr.CommitReplaceLayer(layer); err != nil {
+		return BadRequest("%v", err)
+	}
+
+	logger.Noticef("authz_admin:%s,replace_layer Replacing layer %s", accessTag(user), payload.Label)
+
+	return SyncResponse(true)
+}
+
+func v1ReorderLayers(c *Command, user *UserState, payload layersPayload) Response {
+	if len(payload.Labels) == 0 {
+		return BadRequest("labels must be set")
+	}
+
+	planMgr := c.d.overlord.PlanManager()
+	combined, err := planMgr.PreviewReorderLayers(payload.Labels)
+	if err != nil {
+		return BadRequest("%v", err)
+	}
+
+	if errs := plan.ValidatePlan(combined); len(errs) > 0 {
+		return validationBadRequest(errs)
+	}
+
+	if payload.DryRun {
+		return SyncResponse(diffPlans(planMgr.Plan(), combined, user))
+	}
+
+	if err := planMgr.CommitReorderLayers(payload.Labels); err != nil {
+		return BadRequest("%v", err)
+	}
+
+	logger.Noticef("authz_admin:%s,reorder_layers Reordering layers to %v", accessTag(user), payload.Labels)
+
+	return SyncResponse(true)
+}
+
+// validationBadRequest turns one or more plan.LayerValidator failures into
+// a single 400 response. With exactly one failure the message is that
+// failure's own message, unadorned; with more than one, every failure is
+// listed so a caller can fix them all at once instead of resubmitting one
+// field at a time.
+func validationBadRequest(errs []*plan.FieldError) Response {
+	if len(errs) == 1 {
+		return BadRequest("%s", errs[0].Error())
+	}
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = fmt.Sprintf("%s: %s", err.Pointer, err.Error())
+	}
+	return BadRequest("%d layer validation errors:\n- %s", len(errs), strings.Join(messages, "\n- "))
+}
+
+// evaluateLayerPolicy runs the daemon's admission policy engine, if any,
+// against combined. It returns ok=false and the 403 response to send when
+// the engine denies the change.
+func evaluateLayerPolicy(c *Command, user *UserState, label string, combined *plan.Plan) (Response, bool) {
+	engine := c.d.policyEngine
+	if engine == nil {
+		return nil, true
+	}
+	violations := engine.Evaluate(user, combined)
+	if len(violations) == 0 {
+		return nil, true
+	}
+	logPolicyDenial(user, label, violations)
+	return policyForbidden(violations), false
+}
@@ -16,6 +16,7 @@ package daemon
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"net/http/httptest"
 
@@ -24,6 +25,7 @@ import (
 
 	"github.com/canonical/pebble/internals/logger"
 	"github.com/canonical/pebble/internals/overlord/state"
+	"github.com/canonical/pebble/internals/plan"
 )
 
 var planLayer = `
@@ -110,6 +112,11 @@ func (s *apiSuite) TestLayersErrors(c *C) {
 		{`{"action": "add", "label": "", "format": "yaml"}`, 400, `label must be set`},
 		{`{"action": "add", "label": "x", "format": "xml"}`, 400, `invalid format "xml"`},
 		{`{"action": "add", "label": "x", "format": "yaml", "layer": "@"}`, 400, `cannot parse layer YAML: .*`},
+		{`{"action": "remove", "label": ""}`, 400, `label must be set`},
+		{`{"action": "replace", "label": "", "format": "yaml"}`, 400, `label must be set`},
+		{`{"action": "replace", "label": "x", "format": "xml"}`, 400, `invalid format "xml"`},
+		{`{"action": "replace", "label": "x", "format": "yaml", "layer": "@"}`, 400, `cannot parse layer YAML: .*`},
+		{`{"action": "reorder", "labels": []}`, 400, `labels must be set`},
 	}
 
 	_ = s.daemon(c)
@@ -205,6 +212,298 @@ func (s *apiSuite) TestLayersCombineFormatError(c *C) {
 	c.Assert(result.Message, Matches, `layer "base" must define "override" for service "dynamic"`)
 }
 
+func (s *apiSuite) postLayers(c *C, payload string) *resp {
+	layersCmd := apiCmd("/v1/layers")
+	req, err := http.NewRequest("POST", "/v1/layers", bytes.NewBufferString(payload))
+	c.Assert(err, IsNil)
+	rsp := v1PostLayers(layersCmd, req, nil).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	c.Assert(rec.Code, Equals, 200, Commentf("body: %s", rec.Body.String()))
+	return rsp
+}
+
+func (s *apiSuite) TestLayersRemove(c *C) {
+	writeTestLayer(s.pebbleDir, planLayer)
+	_ = s.daemon(c)
+
+	s.postLayers(c, `{"action": "add", "label": "foo", "format": "yaml", "layer": "services:\n dynamic:\n  override: replace\n  command: echo dynamic\n"}`)
+	s.planLayersHasLen(c, 2)
+
+	s.postLayers(c, `{"action": "remove", "label": "foo"}`)
+	s.planLayersHasLen(c, 1)
+	c.Assert(s.planYAML(c), Equals, `
+services:
+    static:
+        override: replace
+        command: echo static
+`[1:])
+}
+
+func (s *apiSuite) TestLayersRemoveRejectsDanglingCheckReference(c *C) {
+	writeTestLayer(s.pebbleDir, planLayer)
+	_ = s.daemon(c)
+
+	s.postLayers(c, `{"action": "add", "label": "checks", "format": "yaml", "layer": "checks:\n watchdog:\n  override: replace\n  level: alive\n  exec:\n   command: /bin/check\n"}`)
+	s.postLayers(c, `{"action": "add", "label": "foo", "format": "yaml", "layer": "services:\n dynamic:\n  override: replace\n  command: echo dynamic\n  on-check-failure:\n   watchdog: restart\n"}`)
+	s.planLayersHasLen(c, 3)
+
+	layersCmd := apiCmd("/v1/layers")
+	req, err := http.NewRequest("POST", "/v1/layers", bytes.NewBufferString(`{"action": "remove", "label": "checks"}`))
+	c.Assert(err, IsNil)
+	rsp := v1PostLayers(layersCmd, req, nil).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	c.Assert(rec.Code, Equals, 400)
+	c.Assert(rsp.Result.(*errorResult).Message, Matches, `.*refers to check "watchdog", which does not exist.*`)
+
+	// The plan must be unchanged: the layer is still there.
+	s.planLayersHasLen(c, 3)
+}
+
+func (s *apiSuite) TestLayersRemoveRejectsDanglingRequires(c *C) {
+	writeTestLayer(s.pebbleDir, planLayer)
+	_ = s.daemon(c)
+
+	s.postLayers(c, `{"action": "add", "label": "db", "format": "yaml", "layer": "services:\n database:\n  override: replace\n  command: echo db\n"}`)
+	s.postLayers(c, `{"action": "add", "label": "foo", "format": "yaml", "layer": "services:\n dynamic:\n  override: replace\n  command: echo dynamic\n  requires:\n   - database\n"}`)
+	s.planLayersHasLen(c, 3)
+
+	layersCmd := apiCmd("/v1/layers")
+	req, err := http.NewRequest("POST", "/v1/layers", bytes.NewBufferString(`{"action": "remove", "label": "db"}`))
+	c.Assert(err, IsNil)
+	rsp := v1PostLayers(layersCmd, req, nil).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	c.Assert(rec.Code, Equals, 400)
+	c.Assert(rsp.Result.(*errorResult).Message, Matches, `.*refers to service "database" in "requires", which does not exist.*`)
+
+	// The plan must be unchanged: the layer is still there.
+	s.planLayersHasLen(c, 3)
+}
+
+func (s *apiSuite) TestLayersReplace(c *C) {
+	writeTestLayer(s.pebbleDir, planLayer)
+	_ = s.daemon(c)
+
+	s.postLayers(c, `{"action": "add", "label": "foo", "format": "yaml", "layer": "services:\n dynamic:\n  override: replace\n  command: echo dynamic\n"}`)
+	s.postLayers(c, `{"action": "replace", "label": "foo", "format": "yaml", "layer": "services:\n dynamic:\n  override: replace\n  command: echo updated\n"}`)
+
+	s.planLayersHasLen(c, 2)
+	c.Assert(s.planYAML(c), Equals, `
+services:
+    dynamic:
+        override: replace
+        command: echo updated
+    static:
+        override: replace
+        command: echo static
+`[1:])
+}
+
+func (s *apiSuite) TestLayersReorder(c *C) {
+	writeTestLayer(s.pebbleDir, planLayer)
+	_ = s.daemon(c)
+
+	s.postLayers(c, `{"action": "add", "label": "foo", "format": "yaml", "layer": "services:\n dynamic:\n  override: replace\n  command: echo dynamic\n"}`)
+	s.planLayersHasLen(c, 2)
+
+	s.postLayers(c, `{"action": "reorder", "labels": ["foo", "base"]}`)
+	s.planLayersHasLen(c, 2)
+	layers := s.d.overlord.PlanManager().Plan().Layers
+	c.Assert(layers[0].Label, Equals, "foo")
+	c.Assert(layers[1].Label, Equals, "base")
+	c.Assert(s.planYAML(c), Equals, `
+services:
+    dynamic:
+        override: replace
+        command: echo dynamic
+    static:
+        override: replace
+        command: echo static
+`[1:])
+}
+
+func (s *apiSuite) TestLayersRemoveDryRun(c *C) {
+	writeTestLayer(s.pebbleDir, planLayer)
+	_ = s.daemon(c)
+	layersCmd := apiCmd("/v1/layers")
+
+	s.postLayers(c, `{"action": "add", "label": "foo", "format": "yaml", "layer": "services:\n dynamic:\n  override: replace\n  command: echo dynamic\n"}`)
+	s.planLayersHasLen(c, 2)
+
+	payload := `{"action": "remove", "label": "foo", "dry-run": true}`
+	req, err := http.NewRequest("POST", "/v1/layers", bytes.NewBufferString(payload))
+	c.Assert(err, IsNil)
+	rsp := v1PostLayers(layersCmd, req, nil).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	c.Assert(rec.Code, Equals, 200)
+	diff := rsp.Result.(*planDiff)
+	c.Assert(diff.Services, HasLen, 1)
+	c.Assert(diff.Services[0], Equals, entityDiff{Name: "dynamic", Status: "removed"})
+
+	// A dry run must not mutate the plan.
+	s.planLayersHasLen(c, 2)
+}
+
+func (s *apiSuite) TestLayersReorderDryRun(c *C) {
+	writeTestLayer(s.pebbleDir, planLayer)
+	_ = s.daemon(c)
+	layersCmd := apiCmd("/v1/layers")
+
+	s.postLayers(c, `{"action": "add", "label": "foo", "format": "yaml", "layer": "services:\n dynamic:\n  override: replace\n  command: echo dynamic\n"}`)
+	s.planLayersHasLen(c, 2)
+	before := s.planYAML(c)
+
+	payload := `{"action": "reorder", "labels": ["foo", "base"], "dry-run": true}`
+	req, err := http.NewRequest("POST", "/v1/layers", bytes.NewBufferString(payload))
+	c.Assert(err, IsNil)
+	rsp := v1PostLayers(layersCmd, req, nil).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	c.Assert(rec.Code, Equals, 200)
+
+	// A dry run must not mutate the plan: the layer order is unchanged.
+	layers := s.d.overlord.PlanManager().Plan().Layers
+	c.Assert(layers, HasLen, 2)
+	c.Assert(layers[0].Label, Equals, "base")
+	c.Assert(layers[1].Label, Equals, "foo")
+	c.Assert(s.planYAML(c), Equals, before)
+}
+
+func (s *apiSuite) TestLayersAddDryRun(c *C) {
+	writeTestLayer(s.pebbleDir, planLayer)
+	_ = s.daemon(c)
+	layersCmd := apiCmd("/v1/layers")
+
+	payload := `{"action": "add", "label": "foo", "format": "yaml", "dry-run": true, "layer": "services:\n dynamic:\n  override: replace\n  command: echo dynamic\n"}`
+	req, err := http.NewRequest("POST", "/v1/layers", bytes.NewBufferString(payload))
+	c.Assert(err, IsNil)
+	rsp := v1PostLayers(layersCmd, req, nil).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	c.Assert(rec.Code, Equals, 200)
+	diff := rsp.Result.(*planDiff)
+	c.Assert(diff.Services, HasLen, 1)
+	c.Assert(diff.Services[0], Equals, entityDiff{Name: "dynamic", Status: "added"})
+
+	// A dry run must not mutate the plan.
+	s.planLayersHasLen(c, 1)
+	c.Assert(s.planYAML(c), Equals, `
+services:
+    static:
+        override: replace
+        command: echo static
+`[1:])
+}
+
+func (s *apiSuite) TestGetPlanDiff(c *C) {
+	writeTestLayer(s.pebbleDir, planLayer)
+	_ = s.daemon(c)
+
+	s.postLayers(c, `{"action": "add", "label": "foo", "format": "yaml", "layer": "services:\n dynamic:\n  override: replace\n  command: echo dynamic\n"}`)
+
+	planCmd := apiCmd("/v1/plan")
+	req, err := http.NewRequest("GET", "/v1/plan?format=diff&against=base", nil)
+	c.Assert(err, IsNil)
+	rsp := v1GetPlan(planCmd, req, &UserState{Access: state.AdminAccess}).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	c.Assert(rec.Code, Equals, 200, Commentf("body: %s", rec.Body.String()))
+
+	diff := rsp.Result.(*planDiff)
+	c.Assert(diff.Services, HasLen, 1)
+	c.Assert(diff.Services[0], Equals, entityDiff{Name: "dynamic", Status: "added"})
+}
+
+func (s *apiSuite) TestGetPlanDiffRequiresAgainst(c *C) {
+	writeTestLayer(s.pebbleDir, planLayer)
+	_ = s.daemon(c)
+	planCmd := apiCmd("/v1/plan")
+
+	req, err := http.NewRequest("GET", "/v1/plan?format=diff", nil)
+	c.Assert(err, IsNil)
+	rsp := v1GetPlan(planCmd, req, nil).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	c.Assert(rec.Code, Equals, 400)
+	c.Assert(rsp.Result.(*errorResult).Message, Matches, `"against" must be set.*`)
+}
+
+func (s *apiSuite) TestLayersDryRunMasksEnvDiffForNonAdmin(c *C) {
+	planLayerWithEnv := `
+services:
+    static:
+        override: replace
+        command: /bin/test
+        environment:
+            SECRET_KEY: super-secret-value
+`
+	writeTestLayer(s.pebbleDir, planLayerWithEnv)
+	_ = s.daemon(c)
+	layersCmd := apiCmd("/v1/layers")
+
+	payload := `{"action": "replace", "label": "base", "format": "yaml", "dry-run": true, "layer": "services:\n static:\n  override: replace\n  command: /bin/test\n  environment:\n   SECRET_KEY: different-value\n"}`
+	req, err := http.NewRequest("POST", "/v1/layers", bytes.NewBufferString(payload))
+	c.Assert(err, IsNil)
+
+	// Admins see a real "modified" diff of the changed environment.
+	rsp := v1PostLayers(layersCmd, req, &UserState{Access: state.AdminAccess}).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	c.Assert(rec.Code, Equals, 200, Commentf("body: %s", rec.Body.String()))
+	adminDiff := rsp.Result.(*planDiff)
+	c.Assert(adminDiff.Services, HasLen, 1)
+	c.Assert(adminDiff.Services[0].Fields, HasLen, 1)
+	c.Assert(adminDiff.Services[0].Fields[0].Status, Equals, "modified")
+
+	// Read users see it called out as masked, never the literal values.
+	req, err = http.NewRequest("POST", "/v1/layers", bytes.NewBufferString(payload))
+	c.Assert(err, IsNil)
+	rsp = v1PostLayers(layersCmd, req, &UserState{Access: state.ReadAccess}).(*resp)
+	rec = httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	c.Assert(rec.Code, Equals, 200, Commentf("body: %s", rec.Body.String()))
+	readDiff := rsp.Result.(*planDiff)
+	c.Assert(readDiff.Services, HasLen, 1)
+	c.Assert(readDiff.Services[0].Fields, HasLen, 1)
+	c.Assert(readDiff.Services[0].Fields[0].Status, Equals, "masked")
+	c.Assert(readDiff.Services[0].Fields[0].Before, DeepEquals, map[string]string{"SECRET_KEY": maskedValue})
+	c.Assert(readDiff.Services[0].Fields[0].After, DeepEquals, map[string]string{"SECRET_KEY": maskedValue})
+}
+
+type noReservedNamesValidator struct{}
+
+func (noReservedNamesValidator) Validate(ctx context.Context, incoming *plan.Layer, combined *plan.Plan) error {
+	if _, ok := incoming.Services["reserved"]; ok {
+		return &plan.FieldError{
+			Pointer: "/services/reserved",
+			Reason:  `service name "reserved" is not allowed`,
+		}
+	}
+	return nil
+}
+
+func (s *apiSuite) TestLayersCustomValidator(c *C) {
+	defer plan.ResetValidators()
+	plan.RegisterValidator("no-reserved-names", noReservedNamesValidator{})
+
+	writeTestLayer(s.pebbleDir, planLayer)
+	_ = s.daemon(c)
+	layersCmd := apiCmd("/v1/layers")
+
+	payload := `{"action": "add", "label": "foo", "format": "yaml", "layer": "services:\n reserved:\n  override: replace\n  command: echo nope\n"}`
+	req, err := http.NewRequest("POST", "/v1/layers", bytes.NewBufferString(payload))
+	c.Assert(err, IsNil)
+	rsp := v1PostLayers(layersCmd, req, nil).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	c.Assert(rec.Code, Equals, 400)
+	c.Assert(rsp.Result.(*errorResult).Message, Equals, `service name "reserved" is not allowed`)
+	s.planLayersHasLen(c, 1)
+}
+
 func (s *apiSuite) TestGetPlanMasksEnvForNonAdmin(c *C) {
 	planLayerWithEnv := `
 services:
@@ -272,3 +571,63 @@ checks:
 	c.Assert(readResult, Not(Matches), `(?s).*check-secret-value.*`)
 	c.Assert(readResult, Not(Matches), `(?s).*check-token-12345.*`)
 }
+
+func (s *apiSuite) TestGetPlanLeavesSecretRefsUnmaskedForNonAdmin(c *C) {
+	planLayerWithSecretRef := `
+services:
+    test-service:
+        override: replace
+        command: /bin/test
+        environment:
+            DB_PASSWORD: ${secret:file:db-password}
+            PUBLIC_VAR: public-value
+`
+	writeTestLayer(s.pebbleDir, planLayerWithSecretRef)
+	_ = s.daemon(c)
+	planCmd := apiCmd("/v1/plan")
+
+	readUser := &UserState{Access: state.ReadAccess}
+	req, err := http.NewRequest("GET", "/v1/plan?format=yaml", nil)
+	c.Assert(err, IsNil)
+	rsp := v1GetPlan(planCmd, req, readUser).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	c.Assert(rec.Code, Equals, 200)
+	readResult := rsp.Result.(string)
+
+	// A secret reference only names a secret, it doesn't reveal it, so it
+	// passes through even for a non-admin.
+	c.Assert(readResult, Matches, `(?s).*DB_PASSWORD: \$\{secret:file:db-password\}.*`)
+	// Literal values are still masked.
+	c.Assert(readResult, Matches, `(?s).*PUBLIC_VAR: '\*\*\*'.*`)
+}
+
+func (s *apiSuite) TestSecretRefObjectFormNormalizedToStringForm(c *C) {
+	planLayerWithSecretRefObject := `
+services:
+    test-service:
+        override: replace
+        command: /bin/test
+        environment:
+            DB_PASSWORD:
+                secretRef:
+                    backend: file
+                    name: db-password
+`
+	writeTestLayer(s.pebbleDir, planLayerWithSecretRefObject)
+	_ = s.daemon(c)
+	planCmd := apiCmd("/v1/plan")
+
+	adminUser := &UserState{Access: state.AdminAccess}
+	req, err := http.NewRequest("GET", "/v1/plan?format=yaml", nil)
+	c.Assert(err, IsNil)
+	rsp := v1GetPlan(planCmd, req, adminUser).(*resp)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+	c.Assert(rec.Code, Equals, 200)
+
+	// The secretRef object form is normalized to the ${secret:...} string
+	// form as soon as it's parsed, so both spellings look identical from
+	// here on.
+	c.Assert(rsp.Result.(string), Matches, `(?s).*DB_PASSWORD: \$\{secret:file:db-password\}.*`)
+}
@@ -0,0 +1,295 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	"sort"
+
+	"github.com/canonical/pebble/internals/overlord/state"
+	"github.com/canonical/pebble/internals/plan"
+	"github.com/canonical/pebble/internals/secrets"
+)
+
+// maskedValue is what a non-admin user sees in place of any environment
+// value, matching the masking already applied to plain "GET /v1/plan"
+// responses (see TestGetPlanMasksEnvForNonAdmin).
+const maskedValue = "***"
+
+// fieldDiff is one field that differs (or, for a masked field, may differ)
+// between two versions of a service or check.
+type fieldDiff struct {
+	Field string `json:"field"`
+
+	// Status is "modified" or, for a non-admin user looking at an
+	// environment field, "masked".
+	Status string      `json:"status"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// entityDiff describes how a single named service or check changed.
+type entityDiff struct {
+	Name   string      `json:"name"`
+	Status string      `json:"status"` // "added", "removed" or "modified"
+	Fields []fieldDiff `json:"fields,omitempty"`
+}
+
+// planDiff is the structured result of comparing two plans, returned by
+// both "GET /v1/plan?format=diff" and a dry-run "POST /v1/layers".
+type planDiff struct {
+	Services []entityDiff `json:"services,omitempty"`
+	Checks   []entityDiff `json:"checks,omitempty"`
+}
+
+func isAdmin(user *UserState) bool {
+	return user != nil && user.Access == state.AdminAccess
+}
+
+// diffPlans compares before and after from user's point of view: a
+// non-admin user never sees literal environment values, even when they
+// differ, so environment-only changes are reported as "masked" rather than
+// "modified" to avoid implying there's nothing to review.
+func diffPlans(before, after *plan.Plan, user *UserState) *planDiff {
+	d := &planDiff{}
+
+	beforeServices := map[string]*plan.Service{}
+	if before != nil {
+		beforeServices = before.Services
+	}
+	afterServices := map[string]*plan.Service{}
+	if after != nil {
+		afterServices = after.Services
+	}
+	for _, name := range sortedServiceNames(beforeServices, afterServices) {
+		b, a := beforeServices[name], afterServices[name]
+		if diff, ok := diffService(name, b, a, user); ok {
+			d.Services = append(d.Services, diff)
+		}
+	}
+
+	beforeChecks := map[string]*plan.Check{}
+	if before != nil {
+		beforeChecks = before.Checks
+	}
+	afterChecks := map[string]*plan.Check{}
+	if after != nil {
+		afterChecks = after.Checks
+	}
+	for _, name := range sortedCheckNames(beforeChecks, afterChecks) {
+		b, a := beforeChecks[name], afterChecks[name]
+		if diff, ok := diffCheck(name, b, a, user); ok {
+			d.Checks = append(d.Checks, diff)
+		}
+	}
+
+	return d
+}
+
+func diffService(name string, before, after *plan.Service, user *UserState) (entityDiff, bool) {
+	switch {
+	case before == nil && after == nil:
+		return entityDiff{}, false
+	case before == nil:
+		return entityDiff{Name: name, Status: "added"}, true
+	case after == nil:
+		return entityDiff{Name: name, Status: "removed"}, true
+	}
+
+	var fields []fieldDiff
+	fields = appendFieldDiff(fields, "override", before.Override, after.Override)
+	fields = appendFieldDiff(fields, "command", before.Command, after.Command)
+	fields = appendFieldDiff(fields, "user", before.User, after.User)
+	fields = appendFieldDiff(fields, "group", before.Group, after.Group)
+	fields = appendStringSliceFieldDiff(fields, "requires", before.Requires, after.Requires)
+	fields = appendStringSliceFieldDiff(fields, "before", before.Before, after.Before)
+	fields = appendStringSliceFieldDiff(fields, "after", before.After, after.After)
+	fields = appendFieldDiff(fields, "on-failure", before.OnFailure, after.OnFailure)
+	fields = appendStringMapFieldDiff(fields, "on-check-failure", before.OnCheckFailure, after.OnCheckFailure)
+	fields = appendFieldDiff(fields, "on-secret-change", before.OnSecretChange, after.OnSecretChange)
+	if envField, ok := diffEnvironment(before.Environment, after.Environment, user); ok {
+		fields = append(fields, envField)
+	}
+	if len(fields) == 0 {
+		return entityDiff{}, false
+	}
+	return entityDiff{Name: name, Status: "modified", Fields: fields}, true
+}
+
+func diffCheck(name string, before, after *plan.Check, user *UserState) (entityDiff, bool) {
+	switch {
+	case before == nil && after == nil:
+		return entityDiff{}, false
+	case before == nil:
+		return entityDiff{Name: name, Status: "added"}, true
+	case after == nil:
+		return entityDiff{Name: name, Status: "removed"}, true
+	}
+
+	var fields []fieldDiff
+	fields = appendFieldDiff(fields, "override", before.Override, after.Override)
+	fields = appendFieldDiff(fields, "level", before.Level, after.Level)
+
+	var beforeCommand, afterCommand string
+	var beforeEnv, afterEnv map[string]secrets.EnvValue
+	if before.Exec != nil {
+		beforeCommand = before.Exec.Command
+		beforeEnv = before.Exec.Environment
+	}
+	if after.Exec != nil {
+		afterCommand = after.Exec.Command
+		afterEnv = after.Exec.Environment
+	}
+	fields = appendFieldDiff(fields, "exec.command", beforeCommand, afterCommand)
+	if envField, ok := diffEnvironment(beforeEnv, afterEnv, user); ok {
+		fields = append(fields, envField)
+	}
+	if len(fields) == 0 {
+		return entityDiff{}, false
+	}
+	return entityDiff{Name: name, Status: "modified", Fields: fields}, true
+}
+
+// diffEnvironment reports whether an environment block changed. For admins
+// it reports the real before/after maps; for non-admins any change (or
+// possible change) is reported as "masked" rather than leaking values or
+// silently reporting "unchanged".
+func diffEnvironment(before, after map[string]secrets.EnvValue, user *UserState) (fieldDiff, bool) {
+	if isAdmin(user) {
+		if envValuesEqual(before, after) {
+			return fieldDiff{}, false
+		}
+		return fieldDiff{Field: "environment", Status: "modified", Before: before, After: after}, true
+	}
+
+	if envValuesEqual(before, after) {
+		return fieldDiff{}, false
+	}
+
+	// Reuse the same ref-aware masking as "GET /v1/plan?format=yaml"
+	// (see maskSecretEnvironment): a secret reference only names a secret,
+	// it doesn't reveal it, so it's safe to show as-is even here.
+	maskedBefore := maskSecretEnvironment(before)
+	maskedAfter := maskSecretEnvironment(after)
+	if len(maskedBefore) == 0 && len(maskedAfter) == 0 {
+		return fieldDiff{}, false
+	}
+	// The key sets or literal values differ (envValuesEqual already ruled
+	// out identical maps), but a non-admin can't tell whether every masked
+	// value also changed, so report "masked" rather than risking a
+	// misleading "modified" with values it can't show.
+	return fieldDiff{Field: "environment", Status: "masked", Before: maskedBefore, After: maskedAfter}, true
+}
+
+// appendFieldDiff appends a fieldDiff for field if before and after differ.
+func appendFieldDiff(fields []fieldDiff, field string, before, after string) []fieldDiff {
+	if before == after {
+		return fields
+	}
+	return append(fields, fieldDiff{Field: field, Status: "modified", Before: before, After: after})
+}
+
+// appendStringSliceFieldDiff appends a fieldDiff for field unless before and
+// after name the same elements in the same order.
+func appendStringSliceFieldDiff(fields []fieldDiff, field string, before, after []string) []fieldDiff {
+	if stringSlicesEqual(before, after) {
+		return fields
+	}
+	return append(fields, fieldDiff{Field: field, Status: "modified", Before: before, After: after})
+}
+
+// appendStringMapFieldDiff appends a fieldDiff for field if before and
+// after don't hold the same keys and values. Unlike diffEnvironment, this
+// has no masking concept: it's for fields like on-check-failure that don't
+// carry secrets.
+func appendStringMapFieldDiff(fields []fieldDiff, field string, before, after map[string]string) []fieldDiff {
+	if stringMapsEqual(before, after) {
+		return fields
+	}
+	return append(fields, fieldDiff{Field: field, Status: "modified", Before: before, After: after})
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func stringMapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func envValuesEqual(a, b map[string]secrets.EnvValue) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedServiceNames(a, b map[string]*plan.Service) []string {
+	seen := map[string]bool{}
+	var names []string
+	for name := range a {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for name := range b {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedCheckNames(a, b map[string]*plan.Check) []string {
+	seen := map[string]bool{}
+	var names []string
+	for name := range a {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for name := range b {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
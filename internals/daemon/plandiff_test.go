@@ -0,0 +1,199 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/pebble/internals/overlord/state"
+	"github.com/canonical/pebble/internals/plan"
+	"github.com/canonical/pebble/internals/secrets"
+)
+
+type planDiffSuite struct{}
+
+var _ = Suite(&planDiffSuite{})
+
+func (s *planDiffSuite) TestCommandMutation(c *C) {
+	before := &plan.Plan{Services: map[string]*plan.Service{
+		"web": {Command: "echo old"},
+	}}
+	after := &plan.Plan{Services: map[string]*plan.Service{
+		"web": {Command: "echo new"},
+	}}
+
+	diff := diffPlans(before, after, &UserState{Access: state.AdminAccess})
+	c.Assert(diff.Services, HasLen, 1)
+	c.Assert(diff.Services[0].Name, Equals, "web")
+	c.Assert(diff.Services[0].Fields, DeepEquals, []fieldDiff{
+		{Field: "command", Status: "modified", Before: "echo old", After: "echo new"},
+	})
+}
+
+func (s *planDiffSuite) TestCheckRemoval(c *C) {
+	before := &plan.Plan{Checks: map[string]*plan.Check{
+		"ready": {Level: "ready"},
+	}}
+	after := &plan.Plan{Checks: map[string]*plan.Check{}}
+
+	diff := diffPlans(before, after, &UserState{Access: state.AdminAccess})
+	c.Assert(diff.Checks, DeepEquals, []entityDiff{
+		{Name: "ready", Status: "removed"},
+	})
+}
+
+func (s *planDiffSuite) TestServiceAddition(c *C) {
+	before := &plan.Plan{}
+	after := &plan.Plan{Services: map[string]*plan.Service{
+		"web": {Command: "echo web"},
+	}}
+
+	diff := diffPlans(before, after, &UserState{Access: state.AdminAccess})
+	c.Assert(diff.Services, DeepEquals, []entityDiff{
+		{Name: "web", Status: "added"},
+	})
+}
+
+func (s *planDiffSuite) TestUnchangedPlanHasNoDiff(c *C) {
+	p := &plan.Plan{Services: map[string]*plan.Service{
+		"web": {Command: "echo web"},
+	}}
+
+	diff := diffPlans(p, p, &UserState{Access: state.AdminAccess})
+	c.Assert(diff.Services, HasLen, 0)
+	c.Assert(diff.Checks, HasLen, 0)
+}
+
+func (s *planDiffSuite) TestUnchangedEnvironmentNotMaskedForNonAdmin(c *C) {
+	p := &plan.Plan{Services: map[string]*plan.Service{
+		"web": {
+			Command:     "echo web",
+			Environment: map[string]secrets.EnvValue{"API_TOKEN": "same-value"},
+		},
+	}}
+
+	diff := diffPlans(p, p, &UserState{Access: state.ReadAccess})
+	c.Assert(diff.Services, HasLen, 0)
+}
+
+func (s *planDiffSuite) TestUserGroupAndOrderingFieldsDiffed(c *C) {
+	before := &plan.Plan{Services: map[string]*plan.Service{
+		"web": {
+			Command:        "echo web",
+			User:           "pebble",
+			Group:          "pebble",
+			Requires:       []string{"database"},
+			Before:         []string{},
+			After:          []string{},
+			OnFailure:      "restart",
+			OnCheckFailure: map[string]string{"alive": "restart"},
+			OnSecretChange: "restart",
+		},
+	}}
+	after := &plan.Plan{Services: map[string]*plan.Service{
+		"web": {
+			Command:        "echo web",
+			User:           "root",
+			Group:          "root",
+			Requires:       []string{"database", "cache"},
+			Before:         []string{},
+			After:          []string{"database"},
+			OnFailure:      "shutdown",
+			OnCheckFailure: map[string]string{"alive": "ignore"},
+			OnSecretChange: "ignore",
+		},
+	}}
+
+	diff := diffPlans(before, after, &UserState{Access: state.AdminAccess})
+	c.Assert(diff.Services, HasLen, 1)
+	c.Assert(diff.Services[0].Fields, DeepEquals, []fieldDiff{
+		{Field: "user", Status: "modified", Before: "pebble", After: "root"},
+		{Field: "group", Status: "modified", Before: "pebble", After: "root"},
+		{Field: "requires", Status: "modified", Before: []string{"database"}, After: []string{"database", "cache"}},
+		{Field: "after", Status: "modified", Before: []string{}, After: []string{"database"}},
+		{Field: "on-failure", Status: "modified", Before: "restart", After: "shutdown"},
+		{Field: "on-check-failure", Status: "modified",
+			Before: map[string]string{"alive": "restart"}, After: map[string]string{"alive": "ignore"}},
+		{Field: "on-secret-change", Status: "modified", Before: "restart", After: "ignore"},
+	})
+}
+
+func (s *planDiffSuite) TestCheckLevelAndExecCommandDiffed(c *C) {
+	before := &plan.Plan{Checks: map[string]*plan.Check{
+		"ready": {Level: "ready", Exec: &plan.ExecCheck{Command: "echo old"}},
+	}}
+	after := &plan.Plan{Checks: map[string]*plan.Check{
+		"ready": {Level: "alive", Exec: &plan.ExecCheck{Command: "echo new"}},
+	}}
+
+	diff := diffPlans(before, after, &UserState{Access: state.AdminAccess})
+	c.Assert(diff.Checks, HasLen, 1)
+	c.Assert(diff.Checks[0].Fields, DeepEquals, []fieldDiff{
+		{Field: "level", Status: "modified", Before: "ready", After: "alive"},
+		{Field: "exec.command", Status: "modified", Before: "echo old", After: "echo new"},
+	})
+}
+
+func (s *planDiffSuite) TestSecretRefLeftUnmaskedInMaskedEnvironmentDiff(c *C) {
+	before := &plan.Plan{Services: map[string]*plan.Service{
+		"web": {
+			Command: "echo web",
+			Environment: map[string]secrets.EnvValue{
+				"API_TOKEN":   "literal-old",
+				"DB_PASSWORD": "${secret:file:db-password}",
+			},
+		},
+	}}
+	after := &plan.Plan{Services: map[string]*plan.Service{
+		"web": {
+			Command: "echo web",
+			Environment: map[string]secrets.EnvValue{
+				"API_TOKEN":   "literal-new",
+				"DB_PASSWORD": "${secret:file:db-password}",
+			},
+		},
+	}}
+
+	diff := diffPlans(before, after, &UserState{Access: state.ReadAccess})
+	c.Assert(diff.Services, HasLen, 1)
+	c.Assert(diff.Services[0].Fields, DeepEquals, []fieldDiff{
+		{
+			Field:  "environment",
+			Status: "masked",
+			Before: map[string]string{"API_TOKEN": maskedValue, "DB_PASSWORD": "${secret:file:db-password}"},
+			After:  map[string]string{"API_TOKEN": maskedValue, "DB_PASSWORD": "${secret:file:db-password}"},
+		},
+	})
+}
+
+func (s *planDiffSuite) TestChangedEnvironmentMaskedForNonAdmin(c *C) {
+	before := &plan.Plan{Services: map[string]*plan.Service{
+		"web": {Command: "echo web", Environment: map[string]secrets.EnvValue{"API_TOKEN": "old"}},
+	}}
+	after := &plan.Plan{Services: map[string]*plan.Service{
+		"web": {Command: "echo web", Environment: map[string]secrets.EnvValue{"API_TOKEN": "new"}},
+	}}
+
+	diff := diffPlans(before, after, &UserState{Access: state.ReadAccess})
+	c.Assert(diff.Services, HasLen, 1)
+	c.Assert(diff.Services[0].Fields, DeepEquals, []fieldDiff{
+		{
+			Field:  "environment",
+			Status: "masked",
+			Before: map[string]string{"API_TOKEN": maskedValue},
+			After:  map[string]string{"API_TOKEN": maskedValue},
+		},
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	"gopkg.in/yaml.v3"
+
+	"github.com/canonical/pebble/internals/plan"
+	"github.com/canonical/pebble/internals/secrets"
+)
+
+// renderPlanYAML marshals p as YAML from user's point of view, for
+// "GET /v1/plan?format=yaml". Admins see every literal value; everyone else
+// sees every literal environment value masked (see maskSecretEnvironment).
+func renderPlanYAML(p *plan.Plan, user *UserState) ([]byte, error) {
+	if isAdmin(user) {
+		return yaml.Marshal(p)
+	}
+	return yaml.Marshal(maskPlan(p))
+}
+
+// maskPlan returns a copy of p with every service's and check's environment
+// masked for a non-admin viewer.
+func maskPlan(p *plan.Plan) *plan.Plan {
+	if p == nil {
+		return nil
+	}
+	masked := &plan.Plan{
+		Services: make(map[string]*plan.Service, len(p.Services)),
+		Checks:   make(map[string]*plan.Check, len(p.Checks)),
+	}
+	for name, service := range p.Services {
+		clone := *service
+		clone.Environment = toEnvValues(maskSecretEnvironment(service.Environment))
+		masked.Services[name] = &clone
+	}
+	for name, check := range p.Checks {
+		clone := *check
+		if check.Exec != nil {
+			execClone := *check.Exec
+			execClone.Environment = toEnvValues(maskSecretEnvironment(check.Exec.Environment))
+			clone.Exec = &execClone
+		}
+		masked.Checks[name] = &clone
+	}
+	return masked
+}
+
+// toEnvValues wraps a masked map[string]string (from maskSecretEnvironment)
+// back into the map[string]secrets.EnvValue that plan.Service and
+// plan.ExecCheck expect: every masked value is now either "***" or a
+// ${secret:...} reference string, both of which are valid EnvValue content.
+func toEnvValues(env map[string]string) map[string]secrets.EnvValue {
+	if env == nil {
+		return nil
+	}
+	values := make(map[string]secrets.EnvValue, len(env))
+	for k, v := range env {
+		values[k] = secrets.EnvValue(v)
+	}
+	return values
+}
+
+// maskSecretEnvironment masks every literal environment value to "***", but
+// leaves secret references (see internals/secrets) as they are: a
+// reference only names a secret, it doesn't reveal it, so it's safe for a
+// non-admin to see.
+func maskSecretEnvironment(env map[string]secrets.EnvValue) map[string]string {
+	if env == nil {
+		return nil
+	}
+	masked := make(map[string]string, len(env))
+	for k, v := range env {
+		if ref, ok := v.ParseRef(); ok {
+			masked[k] = ref.String()
+			continue
+		}
+		masked[k] = maskedValue
+	}
+	return masked
+}
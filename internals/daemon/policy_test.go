@@ -0,0 +1,302 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/pebble/internals/overlord/state"
+	"github.com/canonical/pebble/internals/plan"
+	"github.com/canonical/pebble/internals/secrets"
+)
+
+type policySuite struct{}
+
+var _ = Suite(&policySuite{})
+
+func writePolicyFile(c *C, dir, name, content string) {
+	err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+	c.Assert(err, IsNil)
+}
+
+func (s *policySuite) TestAllowListDeniesUnlistedService(c *C) {
+	dir := c.MkDir()
+	writePolicyFile(c, dir, "10-services.yaml", `
+rules:
+    - name: known-services-only
+      effect: allow
+      services:
+          names: ["web", "worker-*"]
+`)
+	engine, err := LoadPolicyEngine(dir)
+	c.Assert(err, IsNil)
+
+	combined := &plan.Plan{
+		Services: map[string]*plan.Service{
+			"web":     {Command: "echo web"},
+			"dynamic": {Command: "echo dynamic"},
+		},
+	}
+	violations := engine.Evaluate(&UserState{Access: state.ReadAccess}, combined)
+	c.Assert(violations, HasLen, 1)
+	c.Assert(violations[0].Pointer, Equals, "/services/dynamic")
+}
+
+func (s *policySuite) TestDenyWinsOverAllow(c *C) {
+	dir := c.MkDir()
+	writePolicyFile(c, dir, "10-allow.yaml", `
+rules:
+    - name: allow-all-by-name
+      effect: allow
+      services:
+          names: ["*"]
+`)
+	writePolicyFile(c, dir, "20-deny.yaml", `
+rules:
+    - name: deny-shell
+      effect: deny
+      services:
+          commands: ["/bin/sh"]
+`)
+	engine, err := LoadPolicyEngine(dir)
+	c.Assert(err, IsNil)
+
+	combined := &plan.Plan{
+		Services: map[string]*plan.Service{
+			"web": {Command: "/bin/sh -c serve"},
+		},
+	}
+	violations := engine.Evaluate(&UserState{Access: state.ReadAccess}, combined)
+	c.Assert(violations, HasLen, 1)
+	c.Assert(violations[0].Rule, Equals, "deny-shell")
+	c.Assert(violations[0].Pointer, Equals, "/services/web/command")
+}
+
+func (s *policySuite) TestAdminBypassesPolicy(c *C) {
+	dir := c.MkDir()
+	writePolicyFile(c, dir, "10-deny.yaml", `
+rules:
+    - name: deny-everything
+      effect: deny
+      services:
+          names: ["*"]
+`)
+	engine, err := LoadPolicyEngine(dir)
+	c.Assert(err, IsNil)
+
+	combined := &plan.Plan{
+		Services: map[string]*plan.Service{
+			"web": {Command: "echo web"},
+		},
+	}
+	violations := engine.Evaluate(&UserState{Access: state.AdminAccess}, combined)
+	c.Assert(violations, HasLen, 0)
+}
+
+func (s *policySuite) TestMultipleFilesPrecedenceByName(c *C) {
+	dir := c.MkDir()
+	// "05-" sorts before "10-", so its allow-list is unioned with, not
+	// replaced by, the later file's.
+	writePolicyFile(c, dir, "05-allow-web.yaml", `
+rules:
+    - name: allow-web
+      effect: allow
+      services:
+          names: ["web"]
+`)
+	writePolicyFile(c, dir, "10-allow-worker.yaml", `
+rules:
+    - name: allow-worker
+      effect: allow
+      services:
+          names: ["worker"]
+`)
+	engine, err := LoadPolicyEngine(dir)
+	c.Assert(err, IsNil)
+
+	combined := &plan.Plan{
+		Services: map[string]*plan.Service{
+			"web":    {Command: "echo web"},
+			"worker": {Command: "echo worker"},
+		},
+	}
+	violations := engine.Evaluate(&UserState{Access: state.ReadAccess}, combined)
+	c.Assert(violations, HasLen, 0)
+}
+
+func (s *policySuite) TestRequiredEnvMissing(c *C) {
+	dir := c.MkDir()
+	writePolicyFile(c, dir, "10-env.yaml", `
+rules:
+    - name: require-app-env
+      effect: allow
+      services:
+          names: ["*"]
+          requiredEnv: ["APP_ENV"]
+`)
+	engine, err := LoadPolicyEngine(dir)
+	c.Assert(err, IsNil)
+
+	combined := &plan.Plan{
+		Services: map[string]*plan.Service{
+			"web": {Command: "echo web", Environment: map[string]secrets.EnvValue{}},
+		},
+	}
+	violations := engine.Evaluate(&UserState{Access: state.ReadAccess}, combined)
+	c.Assert(violations, HasLen, 1)
+	c.Assert(violations[0].Pointer, Equals, "/services/web/environment/APP_ENV")
+}
+
+func (s *policySuite) TestNoPolicyDirectoryAdmitsEverything(c *C) {
+	engine, err := LoadPolicyEngine(filepath.Join(c.MkDir(), "missing"))
+	c.Assert(err, IsNil)
+	c.Assert(engine, IsNil)
+}
+
+func (s *policySuite) TestUserGroupOnFailureDenied(c *C) {
+	dir := c.MkDir()
+	writePolicyFile(c, dir, "10-deny.yaml", `
+rules:
+    - name: deny-root
+      effect: deny
+      services:
+          users: ["root"]
+          groups: ["wheel"]
+          onFailure: ["shutdown"]
+`)
+	engine, err := LoadPolicyEngine(dir)
+	c.Assert(err, IsNil)
+
+	combined := &plan.Plan{
+		Services: map[string]*plan.Service{
+			"web":    {Command: "echo web", User: "root"},
+			"worker": {Command: "echo worker", Group: "wheel"},
+			"cron":   {Command: "echo cron", OnFailure: "shutdown"},
+			"ok":     {Command: "echo ok", User: "pebble"},
+		},
+	}
+	violations := engine.Evaluate(&UserState{Access: state.ReadAccess}, combined)
+	c.Assert(violations, HasLen, 3)
+}
+
+func (s *policySuite) TestUserGroupOnFailureAllowList(c *C) {
+	dir := c.MkDir()
+	writePolicyFile(c, dir, "10-allow.yaml", `
+rules:
+    - name: only-pebble-user
+      effect: allow
+      services:
+          names: ["*"]
+          users: ["pebble"]
+`)
+	engine, err := LoadPolicyEngine(dir)
+	c.Assert(err, IsNil)
+
+	combined := &plan.Plan{
+		Services: map[string]*plan.Service{
+			"web": {Command: "echo web", User: "root"},
+		},
+	}
+	violations := engine.Evaluate(&UserState{Access: state.ReadAccess}, combined)
+	c.Assert(violations, HasLen, 1)
+	c.Assert(violations[0].Pointer, Equals, "/services/web/user")
+}
+
+func (s *policySuite) TestCommandAllowListDeniesUnlistedCommand(c *C) {
+	dir := c.MkDir()
+	writePolicyFile(c, dir, "10-allow.yaml", `
+rules:
+    - name: only-web-binary
+      effect: allow
+      services:
+          names: ["*"]
+          commands: ["/bin/web"]
+`)
+	engine, err := LoadPolicyEngine(dir)
+	c.Assert(err, IsNil)
+
+	combined := &plan.Plan{
+		Services: map[string]*plan.Service{
+			"web":   {Command: "/bin/web --port 80"},
+			"shell": {Command: "/bin/sh -c serve"},
+		},
+	}
+	violations := engine.Evaluate(&UserState{Access: state.ReadAccess}, combined)
+	c.Assert(violations, HasLen, 1)
+	c.Assert(violations[0].Pointer, Equals, "/services/shell/command")
+}
+
+func (s *policySuite) TestMultipleFilesUnionCommandsAndNames(c *C) {
+	dir := c.MkDir()
+	writePolicyFile(c, dir, "05-allow-web.yaml", `
+rules:
+    - name: allow-web
+      effect: allow
+      services:
+          names: ["web"]
+          commands: ["/bin/web"]
+`)
+	writePolicyFile(c, dir, "10-allow-worker.yaml", `
+rules:
+    - name: allow-worker
+      effect: allow
+      services:
+          names: ["worker"]
+          commands: ["/bin/worker"]
+`)
+	engine, err := LoadPolicyEngine(dir)
+	c.Assert(err, IsNil)
+
+	combined := &plan.Plan{
+		Services: map[string]*plan.Service{
+			"web":    {Command: "/bin/web"},
+			"worker": {Command: "/bin/worker"},
+		},
+	}
+	violations := engine.Evaluate(&UserState{Access: state.ReadAccess}, combined)
+	c.Assert(violations, HasLen, 0)
+}
+
+func (s *policySuite) TestScopeWriteMatchesNonAdminNonReadUser(c *C) {
+	dir := c.MkDir()
+	writePolicyFile(c, dir, "10-deny.yaml", `
+rules:
+    - name: deny-writers
+      effect: deny
+      scope: ["write"]
+      services:
+          names: ["*"]
+`)
+	engine, err := LoadPolicyEngine(dir)
+	c.Assert(err, IsNil)
+
+	combined := &plan.Plan{
+		Services: map[string]*plan.Service{
+			"web": {Command: "echo web"},
+		},
+	}
+
+	// A hypothetical access level that is neither admin nor read-only is
+	// tagged "write" and so is caught by this rule...
+	violations := engine.Evaluate(&UserState{Access: state.Access(99)}, combined)
+	c.Assert(violations, HasLen, 1)
+
+	// ...while a read-only user is not.
+	violations = engine.Evaluate(&UserState{Access: state.ReadAccess}, combined)
+	c.Assert(violations, HasLen, 0)
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package plan
+
+import (
+	"context"
+	"fmt"
+)
+
+// builtinValidators always run first, ahead of anything registered with
+// RegisterValidator.
+var builtinValidators = []namedValidator{
+	{"combine-override", combineOverrideValidator{}},
+	{"secret-change", secretChangeValidator{}},
+}
+
+type combineKey struct{}
+
+// WithCombine returns a copy of ctx carrying whether the layer being
+// validated is being combined into an existing layer of the same label (as
+// opposed to appended as a new layer). The override validator below is the
+// only thing that currently reads it.
+func WithCombine(ctx context.Context, combine bool) context.Context {
+	return context.WithValue(ctx, combineKey{}, combine)
+}
+
+func combineFromContext(ctx context.Context) bool {
+	combine, _ := ctx.Value(combineKey{}).(bool)
+	return combine
+}
+
+// combineOverrideValidator enforces that every service a combined layer
+// touches says how it wants to interact with the existing definition under
+// the same label, by setting "override". Without it, combining layers is
+// ambiguous: does the new service replace the old one, or merge with it?
+type combineOverrideValidator struct{}
+
+func (combineOverrideValidator) Validate(ctx context.Context, incoming *Layer, combined *Plan) error {
+	if !combineFromContext(ctx) {
+		return nil
+	}
+	for name, service := range incoming.Services {
+		if service.Override == "" {
+			return &FieldError{
+				Pointer: fmt.Sprintf("/services/%s/override", name),
+				Reason:  fmt.Sprintf("layer %q must define \"override\" for service %q", incoming.Label, name),
+			}
+		}
+	}
+	return nil
+}
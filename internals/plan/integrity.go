@@ -0,0 +1,58 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package plan
+
+import "fmt"
+
+// ValidatePlan checks combined for internal consistency that isn't tied to
+// any single incoming layer, such as a service referring to a check or
+// another service that no longer exists. It's meant for plan mutations
+// that don't have an incoming layer to run through ValidateLayer, such as
+// removing or reordering layers: either of those can make a reference that
+// was valid before dangle, even though neither touches the service or
+// check making it.
+func ValidatePlan(combined *Plan) []*FieldError {
+	var errs []*FieldError
+	for name, service := range combined.Services {
+		for checkName := range service.OnCheckFailure {
+			if _, ok := combined.Checks[checkName]; !ok {
+				errs = append(errs, &FieldError{
+					Pointer: fmt.Sprintf("/services/%s/on-check-failure/%s", name, checkName),
+					Reason:  fmt.Sprintf("service %q refers to check %q, which does not exist", name, checkName),
+				})
+			}
+		}
+		errs = append(errs, danglingServiceRefs(name, "requires", service.Requires, combined)...)
+		errs = append(errs, danglingServiceRefs(name, "before", service.Before, combined)...)
+		errs = append(errs, danglingServiceRefs(name, "after", service.After, combined)...)
+	}
+	return errs
+}
+
+// danglingServiceRefs returns a FieldError for every name in refs (the
+// service name's requires, before or after list, identified by field) that
+// isn't a service in combined.
+func danglingServiceRefs(name, field string, refs []string, combined *Plan) []*FieldError {
+	var errs []*FieldError
+	for i, ref := range refs {
+		if _, ok := combined.Services[ref]; !ok {
+			errs = append(errs, &FieldError{
+				Pointer: fmt.Sprintf("/services/%s/%s/%d", name, field, i),
+				Reason:  fmt.Sprintf("service %q refers to service %q in %q, which does not exist", name, ref, field),
+			})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package plan
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type integritySuite struct{}
+
+var _ = Suite(&integritySuite{})
+
+func (s *integritySuite) TestValidatePlanDanglingCheckReference(c *C) {
+	combined := &Plan{
+		Services: map[string]*Service{
+			"web": {Command: "echo web", OnCheckFailure: map[string]string{"alive": "restart"}},
+		},
+		Checks: map[string]*Check{},
+	}
+	errs := ValidatePlan(combined)
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errs[0].Pointer, Equals, "/services/web/on-check-failure/alive")
+}
+
+func (s *integritySuite) TestValidatePlanNoDanglingReferences(c *C) {
+	combined := &Plan{
+		Services: map[string]*Service{
+			"web": {Command: "echo web", OnCheckFailure: map[string]string{"alive": "restart"}},
+		},
+		Checks: map[string]*Check{
+			"alive": {Level: "alive"},
+		},
+	}
+	errs := ValidatePlan(combined)
+	c.Assert(errs, HasLen, 0)
+}
+
+func (s *integritySuite) TestValidatePlanDanglingRequiresBeforeAfter(c *C) {
+	combined := &Plan{
+		Services: map[string]*Service{
+			"web": {
+				Command:  "echo web",
+				Requires: []string{"database"},
+				Before:   []string{"cleanup"},
+				After:    []string{"migrate"},
+			},
+		},
+	}
+	errs := ValidatePlan(combined)
+	c.Assert(errs, HasLen, 3)
+	c.Assert(errs[0].Pointer, Equals, "/services/web/requires/0")
+	c.Assert(errs[1].Pointer, Equals, "/services/web/before/0")
+	c.Assert(errs[2].Pointer, Equals, "/services/web/after/0")
+}
+
+func (s *integritySuite) TestValidatePlanRequiresBeforeAfterResolve(c *C) {
+	combined := &Plan{
+		Services: map[string]*Service{
+			"web":      {Command: "echo web", Requires: []string{"database"}, Before: []string{"database"}},
+			"database": {Command: "echo db"},
+		},
+	}
+	errs := ValidatePlan(combined)
+	c.Assert(errs, HasLen, 0)
+}
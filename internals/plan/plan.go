@@ -0,0 +1,100 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package plan
+
+import (
+	"gopkg.in/yaml.v3"
+
+	"github.com/canonical/pebble/internals/secrets"
+)
+
+// Plan is the fully combined result of stacking every layer, in label
+// order, on top of each other.
+type Plan struct {
+	Services map[string]*Service `yaml:"services,omitempty"`
+	Checks   map[string]*Check   `yaml:"checks,omitempty"`
+	Layers   []*Layer            `yaml:"-"`
+}
+
+// Layer is a single, independently-addable slice of configuration, as
+// pushed to "POST /v1/layers" or loaded from a layer file on disk.
+type Layer struct {
+	Label       string              `yaml:"-"`
+	Summary     string              `yaml:"summary,omitempty"`
+	Description string              `yaml:"description,omitempty"`
+	Services    map[string]*Service `yaml:"services,omitempty"`
+	Checks      map[string]*Check   `yaml:"checks,omitempty"`
+}
+
+// Service is one service entry of a layer or combined plan.
+type Service struct {
+	Override string `yaml:"override,omitempty"`
+	Command  string `yaml:"command,omitempty"`
+
+	User  string `yaml:"user,omitempty"`
+	Group string `yaml:"group,omitempty"`
+
+	// Requires, Before and After name other services in the plan that this
+	// service depends on for startup ordering: Requires must also be
+	// started whenever this service is; Before and After order this
+	// service's startup relative to the named services.
+	Requires []string `yaml:"requires,omitempty"`
+	Before   []string `yaml:"before,omitempty"`
+	After    []string `yaml:"after,omitempty"`
+
+	// Environment values may be a plain string (literal, or a
+	// ${secret:backend:name} reference) or a secretRef mapping; see
+	// secrets.EnvValue.
+	Environment map[string]secrets.EnvValue `yaml:"environment,omitempty"`
+
+	// OnFailure is what to do when the service itself exits unexpectedly,
+	// e.g. "restart" or "shutdown".
+	OnFailure string `yaml:"on-failure,omitempty"`
+
+	// OnCheckFailure is what to do when a named health check fails,
+	// keyed by check name.
+	OnCheckFailure map[string]string `yaml:"on-check-failure,omitempty"`
+
+	// OnSecretChange is what to do when one of this service's environment
+	// values resolves to a secret reference (see internals/secrets) whose
+	// underlying secret changes: "restart", "ignore", or "signal:<name>"
+	// (e.g. "signal:HUP"). Defaults to "restart" when unset.
+	OnSecretChange string `yaml:"on-secret-change,omitempty"`
+}
+
+// Check is one health check entry of a layer or combined plan.
+type Check struct {
+	Override string     `yaml:"override,omitempty"`
+	Level    string     `yaml:"level,omitempty"`
+	Exec     *ExecCheck `yaml:"exec,omitempty"`
+}
+
+// ExecCheck is the "exec" variant of a Check: it passes if Command exits
+// zero.
+type ExecCheck struct {
+	Command     string                      `yaml:"command,omitempty"`
+	Environment map[string]secrets.EnvValue `yaml:"environment,omitempty"`
+}
+
+// ParseLayer parses data as a layer's YAML body and sets its label to
+// label.
+func ParseLayer(data []byte, label string) (*Layer, error) {
+	var layer Layer
+	if err := yaml.Unmarshal(data, &layer); err != nil {
+		return nil, err
+	}
+	layer.Label = label
+	return &layer, nil
+}
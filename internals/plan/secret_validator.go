@@ -0,0 +1,42 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package plan
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/canonical/pebble/internals/secrets"
+)
+
+// secretChangeValidator rejects a service whose "on-secret-change" field
+// doesn't parse, so a bad value is caught when the layer is pushed rather
+// than the first time a referenced secret actually changes.
+type secretChangeValidator struct{}
+
+func (secretChangeValidator) Validate(ctx context.Context, incoming *Layer, combined *Plan) error {
+	for name, service := range incoming.Services {
+		if service.OnSecretChange == "" {
+			continue
+		}
+		if _, err := secrets.ParseOnChange(service.OnSecretChange); err != nil {
+			return &FieldError{
+				Pointer: fmt.Sprintf("/services/%s/on-secret-change", name),
+				Reason:  err.Error(),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package plan
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// LayerValidator is implemented by anything that wants to veto or flag an
+// incoming layer before it's combined or appended to the plan. It covers
+// checks that need the parsed layer and the plan it would produce, such as
+// the built-in combine-mode override check and, for third-party builds,
+// things like resource-limit sanity or naming conventions.
+//
+// It deliberately does not cover the label, format and YAML-syntax checks
+// at the top of v1AddLayer and v1ReplaceLayer: those reject a request
+// before a Layer exists to pass in, so there's nothing for a validator to
+// receive. They stay as plain ifs in the handlers rather than being forced
+// into this chain.
+type LayerValidator interface {
+	// Validate is called with the incoming layer and the plan that would
+	// result from adding it. It returns at most one error: ValidateLayer
+	// records a returned *FieldError as is, and any other error as a single
+	// FieldError with no pointer whose reason is prefixed with the
+	// validator's registered name. ValidateLayer runs every validator
+	// regardless of whether an earlier one failed.
+	Validate(ctx context.Context, incoming *Layer, combined *Plan) error
+}
+
+// FieldError reports a single problem with an incoming layer, identified by
+// a JSON pointer path (RFC 6901) so a caller can point at exactly the field
+// that needs to change.
+type FieldError struct {
+	Pointer string
+	Reason  string
+}
+
+func (e *FieldError) Error() string {
+	return e.Reason
+}
+
+type namedValidator struct {
+	name string
+	v    LayerValidator
+}
+
+var (
+	validatorsMu sync.Mutex
+	validators   []namedValidator
+)
+
+// RegisterValidator adds v, identified by name, to the chain of validators
+// run against every incoming layer, alongside the built-in checks. Builds
+// that need extra checks call this from an init function, typically gated
+// by a build tag so the extra validator only exists in that build.
+// Registering the same name twice replaces the earlier validator.
+func RegisterValidator(name string, v LayerValidator) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	for i, nv := range validators {
+		if nv.name == name {
+			validators[i].v = v
+			return
+		}
+	}
+	validators = append(validators, namedValidator{name, v})
+}
+
+// ResetValidators removes every validator added with RegisterValidator,
+// restoring the chain to just the built-ins. The registry is process-global,
+// so tests that register a validator for the duration of a single test
+// should defer a call to this to avoid leaking it into later tests.
+func ResetValidators() {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	validators = nil
+}
+
+// ValidateLayer runs the built-in validators followed by every validator
+// registered with RegisterValidator, in registration order, against
+// incoming and combined. It collects every failure rather than stopping at
+// the first, so a single response can list everything wrong with a layer.
+func ValidateLayer(ctx context.Context, incoming *Layer, combined *Plan) []*FieldError {
+	validatorsMu.Lock()
+	chain := make([]namedValidator, 0, len(builtinValidators)+len(validators))
+	chain = append(chain, builtinValidators...)
+	chain = append(chain, validators...)
+	validatorsMu.Unlock()
+
+	var errs []*FieldError
+	for _, nv := range chain {
+		err := nv.v.Validate(ctx, incoming, combined)
+		if err == nil {
+			continue
+		}
+		if fe, ok := err.(*FieldError); ok {
+			errs = append(errs, fe)
+			continue
+		}
+		errs = append(errs, &FieldError{Reason: fmt.Sprintf("%s: %v", nv.name, err)})
+	}
+	return errs
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package plan
+
+import (
+	"context"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type validatorSuite struct{}
+
+var _ = Suite(&validatorSuite{})
+
+func (s *validatorSuite) TestCombineOverrideRequired(c *C) {
+	incoming := &Layer{
+		Label: "base",
+		Services: map[string]*Service{
+			"dynamic": {Command: "echo dynamic"},
+		},
+	}
+	ctx := WithCombine(context.Background(), true)
+	errs := ValidateLayer(ctx, incoming, &Plan{})
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errs[0].Pointer, Equals, "/services/dynamic/override")
+	c.Assert(errs[0].Error(), Equals, `layer "base" must define "override" for service "dynamic"`)
+}
+
+func (s *validatorSuite) TestCombineOverrideNotRequiredWhenAppending(c *C) {
+	incoming := &Layer{
+		Label: "base",
+		Services: map[string]*Service{
+			"dynamic": {Command: "echo dynamic"},
+		},
+	}
+	ctx := context.Background() // not a combine
+	errs := ValidateLayer(ctx, incoming, &Plan{})
+	c.Assert(errs, HasLen, 0)
+}
+
+func (s *validatorSuite) TestSecretChangeInvalid(c *C) {
+	incoming := &Layer{
+		Label: "base",
+		Services: map[string]*Service{
+			"web": {Override: "replace", Command: "echo web", OnSecretChange: "bogus"},
+		},
+	}
+	errs := ValidateLayer(context.Background(), incoming, &Plan{})
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errs[0].Pointer, Equals, "/services/web/on-secret-change")
+}
+
+func (s *validatorSuite) TestSecretChangeValid(c *C) {
+	incoming := &Layer{
+		Label: "base",
+		Services: map[string]*Service{
+			"web": {Override: "replace", Command: "echo web", OnSecretChange: "signal:HUP"},
+		},
+	}
+	errs := ValidateLayer(context.Background(), incoming, &Plan{})
+	c.Assert(errs, HasLen, 0)
+}
+
+type alwaysFailValidator struct {
+	pointer string
+}
+
+func (v alwaysFailValidator) Validate(ctx context.Context, incoming *Layer, combined *Plan) error {
+	return &FieldError{Pointer: v.pointer, Reason: "nope"}
+}
+
+func (s *validatorSuite) TestRegisterValidatorRunsAlongsideBuiltins(c *C) {
+	defer ResetValidators()
+
+	RegisterValidator("custom", alwaysFailValidator{pointer: "/services/web"})
+
+	incoming := &Layer{Label: "base", Services: map[string]*Service{}}
+	errs := ValidateLayer(context.Background(), incoming, &Plan{})
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errs[0].Pointer, Equals, "/services/web")
+}
+
+func (s *validatorSuite) TestRegisterValidatorReplacesSameName(c *C) {
+	defer ResetValidators()
+
+	RegisterValidator("custom", alwaysFailValidator{pointer: "/services/a"})
+	RegisterValidator("custom", alwaysFailValidator{pointer: "/services/b"})
+
+	incoming := &Layer{Label: "base", Services: map[string]*Service{}}
+	errs := ValidateLayer(context.Background(), incoming, &Plan{})
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errs[0].Pointer, Equals, "/services/b")
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// EnvBackend resolves secrets from pebbled's own process environment. It's
+// mainly useful for tests and for deployments that already inject secrets
+// into the daemon's environment via some other mechanism (e.g. a secrets
+// manager sidecar).
+type EnvBackend struct{}
+
+func (EnvBackend) Get(ctx context.Context, name string) ([]byte, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %q is not set", name)
+	}
+	return []byte(value), nil
+}
+
+// Watch never fires: pebbled's own environment doesn't change after it
+// starts. The returned channel is only closed when ctx is done.
+func (EnvBackend) Watch(ctx context.Context, name string) (<-chan struct{}, error) {
+	ch := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// ExecBackend resolves a secret by running Command with name as its only
+// argument and taking the trimmed stdout as the secret value. It's the
+// escape hatch for secrets that live behind a vault CLI, a cloud provider's
+// secret-manager tool, or any other helper pebbled shouldn't need to know
+// the details of.
+type ExecBackend struct {
+	Command string
+}
+
+// NewExecBackend returns an ExecBackend that runs command to fetch secrets.
+func NewExecBackend(command string) *ExecBackend {
+	return &ExecBackend{Command: command}
+}
+
+func (b *ExecBackend) run(ctx context.Context, name string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, b.Command, name)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("secret helper %q failed: %w", b.Command, err)
+	}
+	return bytes.TrimRight(stdout.Bytes(), "\n"), nil
+}
+
+func (b *ExecBackend) Get(ctx context.Context, name string) ([]byte, error) {
+	return b.run(ctx, name)
+}
+
+// Watch polls the helper command at pollInterval and fires whenever the
+// returned value's hash changes. Running the helper repeatedly is the only
+// portable way to detect change for an arbitrary command.
+func (b *ExecBackend) Watch(ctx context.Context, name string) (<-chan struct{}, error) {
+	value, err := b.run(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	lastHash := sha256.Sum256(value)
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				value, err := b.run(ctx, name)
+				if err != nil {
+					continue
+				}
+				hash := sha256.Sum256(value)
+				if hash != lastHash {
+					lastHash = hash
+					select {
+					case ch <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// pollInterval is how often FileBackend checks a watched secret's mtime for
+// changes. Secret files are expected to change rarely, so a short poll is
+// cheap and avoids depending on a filesystem notification library.
+const pollInterval = 2 * time.Second
+
+// FileBackend resolves secrets as files under a single directory: the
+// secret named "db-password" is the contents of Dir+"/db-password".
+type FileBackend struct {
+	Dir string
+}
+
+// NewFileBackend returns a FileBackend that reads secrets from dir.
+func NewFileBackend(dir string) *FileBackend {
+	return &FileBackend{Dir: dir}
+}
+
+func (b *FileBackend) path(name string) (string, error) {
+	path := filepath.Join(b.Dir, name)
+	if filepath.Dir(path) != filepath.Clean(b.Dir) {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	return path, nil
+}
+
+func (b *FileBackend) Get(ctx context.Context, name string) ([]byte, error) {
+	path, err := b.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+func (b *FileBackend) Watch(ctx context.Context, name string) (<-chan struct{}, error) {
+	path, err := b.path(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	lastMod := info.ModTime()
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				info, err := os.Stat(path)
+				if err != nil {
+					continue
+				}
+				if info.ModTime().After(lastMod) {
+					lastMod = info.ModTime()
+					select {
+					case ch <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
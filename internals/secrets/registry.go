@@ -0,0 +1,68 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Registry holds the configured secret backends, keyed by the name used in
+// a Ref (for example "file", "env", "exec").
+type Registry struct {
+	mu       sync.RWMutex
+	backends map[string]Backend
+}
+
+// NewRegistry returns an empty Registry; backends are added with Register.
+func NewRegistry() *Registry {
+	return &Registry{backends: make(map[string]Backend)}
+}
+
+// Register adds backend under name, replacing any existing backend with
+// that name.
+func (r *Registry) Register(name string, backend Backend) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.backends[name] = backend
+}
+
+// Backend returns the backend registered under name, or an error if none is
+// registered.
+func (r *Registry) Backend(name string) (Backend, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	backend, ok := r.backends[name]
+	if !ok {
+		return nil, fmt.Errorf("no secret backend registered with name %q", name)
+	}
+	return backend, nil
+}
+
+// Resolve looks up ref's backend and returns the current value of ref.Name.
+// The returned bytes are the literal secret; callers must not pass them
+// anywhere they could be logged or returned over the API.
+func (r *Registry) Resolve(ctx context.Context, ref Ref) ([]byte, error) {
+	backend, err := r.Backend(ref.Backend)
+	if err != nil {
+		return nil, err
+	}
+	value, err := backend.Get(ctx, ref.Name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve secret %s: %w", ref, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package secrets resolves the secretRef values that may appear in a plan's
+// service or check environment blocks (for example
+// {secretRef: {backend: "file", name: "db-password"}}, or the equivalent
+// ${secret:file:db-password} string form) into the literal bytes a service
+// needs at start time, without ever letting those bytes flow back out
+// through the API. Plan and API code only ever handle a Ref; only the
+// service manager, at the point it builds a child process's environment,
+// calls Registry.Resolve to get the actual value.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Backend resolves named secrets from a single source: a directory of
+// files, the daemon's own environment, or a helper command.
+type Backend interface {
+	// Get returns the current value of the named secret.
+	Get(ctx context.Context, name string) ([]byte, error)
+
+	// Watch returns a channel that receives a value every time the named
+	// secret changes, until ctx passed to the Watch call is done, at which
+	// point the channel is closed. Backends that can't detect changes
+	// (such as the env backend) return a channel that's never written to.
+	Watch(ctx context.Context, name string) (<-chan struct{}, error)
+}
+
+// Ref identifies a single secret value by backend and name, as parsed from
+// a secretRef block or a ${secret:backend:name} interpolation in a layer's
+// environment.
+type Ref struct {
+	Backend string `yaml:"backend"`
+	Name    string `yaml:"name"`
+}
+
+func (r Ref) String() string {
+	return fmt.Sprintf("${secret:%s:%s}", r.Backend, r.Name)
+}
+
+var refPattern = regexp.MustCompile(`^\$\{secret:([^:}]+):([^}]+)\}$`)
+
+// ParseRef parses the ${secret:backend:name} string interpolation form of a
+// secret reference. It returns ok=false if s is not a secret reference, so
+// that callers can fall through to treating it as a literal value.
+func ParseRef(s string) (ref Ref, ok bool) {
+	m := refPattern.FindStringSubmatch(s)
+	if m == nil {
+		return Ref{}, false
+	}
+	return Ref{Backend: m[1], Name: m[2]}, true
+}
+
+// EnvValue is a single value in a service or check's environment block. In
+// a layer it can be written either as a plain string (a literal value, or a
+// ${secret:backend:name} reference) or as a secretRef mapping, e.g.
+// {secretRef: {backend: "file", name: "db-password"}}; both spellings of a
+// reference are normalized to the ${secret:...} string form, so everything
+// downstream (diffing, masking, resolving) only has to understand one.
+type EnvValue string
+
+// secretRefValue is the object form of a secret reference, as it appears
+// under an environment key in a layer.
+type secretRefValue struct {
+	SecretRef *Ref `yaml:"secretRef"`
+}
+
+func (v *EnvValue) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		var s string
+		if err := node.Decode(&s); err != nil {
+			return err
+		}
+		*v = EnvValue(s)
+		return nil
+	}
+	var ref secretRefValue
+	if err := node.Decode(&ref); err != nil || ref.SecretRef == nil {
+		return fmt.Errorf("environment value must be a string or a secretRef mapping")
+	}
+	*v = EnvValue(ref.SecretRef.String())
+	return nil
+}
+
+func (v EnvValue) MarshalYAML() (interface{}, error) {
+	return string(v), nil
+}
+
+// ParseRef parses v the same way the package-level ParseRef parses a
+// string, since UnmarshalYAML has already normalized the secretRef object
+// form down to the ${secret:backend:name} string form.
+func (v EnvValue) ParseRef() (ref Ref, ok bool) {
+	return ParseRef(string(v))
+}
+
+// ResolveEnvironment returns a copy of env with every secret reference
+// resolved, via registry, to the literal value it names; values that
+// aren't a reference are copied through unchanged. This is the function
+// the service manager calls at the point it builds a child process's
+// environment, immediately before starting the service or check the
+// environment belongs to — the resolved bytes must go straight into that
+// process and nowhere else, per the package doc above.
+func ResolveEnvironment(ctx context.Context, registry *Registry, env map[string]EnvValue) (map[string]string, error) {
+	if env == nil {
+		return nil, nil
+	}
+	resolved := make(map[string]string, len(env))
+	for name, value := range env {
+		ref, ok := value.ParseRef()
+		if !ok {
+			resolved[name] = string(value)
+			continue
+		}
+		secret, err := registry.Resolve(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve environment variable %q: %w", name, err)
+		}
+		resolved[name] = string(secret)
+	}
+	return resolved, nil
+}
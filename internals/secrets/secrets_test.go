@@ -0,0 +1,202 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package secrets_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/pebble/internals/secrets"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type secretsSuite struct{}
+
+var _ = Suite(&secretsSuite{})
+
+func (s *secretsSuite) TestParseRef(c *C) {
+	ref, ok := secrets.ParseRef("${secret:file:db-password}")
+	c.Assert(ok, Equals, true)
+	c.Assert(ref, Equals, secrets.Ref{Backend: "file", Name: "db-password"})
+	c.Assert(ref.String(), Equals, "${secret:file:db-password}")
+
+	_, ok = secrets.ParseRef("not-a-ref")
+	c.Assert(ok, Equals, false)
+}
+
+func (s *secretsSuite) TestFileBackend(c *C) {
+	dir := c.MkDir()
+	err := os.WriteFile(filepath.Join(dir, "db-password"), []byte("hunter2"), 0o600)
+	c.Assert(err, IsNil)
+
+	backend := secrets.NewFileBackend(dir)
+	value, err := backend.Get(context.Background(), "db-password")
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "hunter2")
+
+	_, err = backend.Get(context.Background(), "../escape")
+	c.Assert(err, ErrorMatches, `invalid secret name ".*"`)
+}
+
+func (s *secretsSuite) TestEnvBackend(c *C) {
+	c.Setenv("PEBBLE_TEST_SECRET", "from-env")
+	backend := secrets.EnvBackend{}
+	value, err := backend.Get(context.Background(), "PEBBLE_TEST_SECRET")
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "from-env")
+
+	_, err = backend.Get(context.Background(), "PEBBLE_TEST_SECRET_UNSET")
+	c.Assert(err, ErrorMatches, `environment variable ".*" is not set`)
+}
+
+func (s *secretsSuite) TestRegistryResolve(c *C) {
+	dir := c.MkDir()
+	err := os.WriteFile(filepath.Join(dir, "token"), []byte("abc123"), 0o600)
+	c.Assert(err, IsNil)
+
+	registry := secrets.NewRegistry()
+	registry.Register("file", secrets.NewFileBackend(dir))
+
+	value, err := registry.Resolve(context.Background(), secrets.Ref{Backend: "file", Name: "token"})
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "abc123")
+
+	_, err = registry.Resolve(context.Background(), secrets.Ref{Backend: "vault", Name: "token"})
+	c.Assert(err, ErrorMatches, `no secret backend registered with name "vault"`)
+}
+
+func (s *secretsSuite) TestParseOnChange(c *C) {
+	oc, err := secrets.ParseOnChange("")
+	c.Assert(err, IsNil)
+	c.Assert(oc, Equals, secrets.OnChange{Action: "restart"})
+
+	oc, err = secrets.ParseOnChange("ignore")
+	c.Assert(err, IsNil)
+	c.Assert(oc, Equals, secrets.OnChange{Action: "ignore"})
+
+	oc, err = secrets.ParseOnChange("signal:HUP")
+	c.Assert(err, IsNil)
+	c.Assert(oc, Equals, secrets.OnChange{Action: "signal", Signal: "HUP"})
+
+	_, err = secrets.ParseOnChange("signal:")
+	c.Assert(err, ErrorMatches, `invalid on-secret-change "signal:": signal name must be set`)
+
+	_, err = secrets.ParseOnChange("bogus")
+	c.Assert(err, ErrorMatches, `invalid on-secret-change "bogus"`)
+}
+
+func (s *secretsSuite) TestResolveEnvironment(c *C) {
+	dir := c.MkDir()
+	err := os.WriteFile(filepath.Join(dir, "db-password"), []byte("hunter2"), 0o600)
+	c.Assert(err, IsNil)
+
+	registry := secrets.NewRegistry()
+	registry.Register("file", secrets.NewFileBackend(dir))
+
+	env := map[string]secrets.EnvValue{
+		"DB_PASSWORD": "${secret:file:db-password}",
+		"PUBLIC_VAR":  "public-value",
+	}
+	resolved, err := secrets.ResolveEnvironment(context.Background(), registry, env)
+	c.Assert(err, IsNil)
+	c.Assert(resolved, DeepEquals, map[string]string{
+		"DB_PASSWORD": "hunter2",
+		"PUBLIC_VAR":  "public-value",
+	})
+
+	_, err = secrets.ResolveEnvironment(context.Background(), registry, map[string]secrets.EnvValue{
+		"MISSING": "${secret:vault:token}",
+	})
+	c.Assert(err, ErrorMatches, `cannot resolve environment variable "MISSING": .*`)
+}
+
+func (s *secretsSuite) TestEnvValueUnmarshalYAML(c *C) {
+	var parsed struct {
+		Environment map[string]secrets.EnvValue `yaml:"environment"`
+	}
+	err := yaml.Unmarshal([]byte(`
+environment:
+    API_TOKEN: literal-value
+    STRING_REF: ${secret:file:db-password}
+    OBJECT_REF:
+        secretRef:
+            backend: file
+            name: db-password
+`), &parsed)
+	c.Assert(err, IsNil)
+	c.Assert(parsed.Environment, DeepEquals, map[string]secrets.EnvValue{
+		"API_TOKEN":  "literal-value",
+		"STRING_REF": "${secret:file:db-password}",
+		"OBJECT_REF": "${secret:file:db-password}",
+	})
+
+	err = yaml.Unmarshal([]byte(`
+environment:
+    BAD: {notSecretRef: true}
+`), &parsed)
+	c.Assert(err, ErrorMatches, `(?s).*environment value must be a string or a secretRef mapping.*`)
+}
+
+type fakeNotifier struct {
+	restarted chan string
+}
+
+func (f *fakeNotifier) RestartService(name string) {
+	f.restarted <- name
+}
+
+func (f *fakeNotifier) SignalService(name, signal string) error {
+	return nil
+}
+
+func (s *secretsSuite) TestWatcherRestartsOnChange(c *C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "db-password")
+	err := os.WriteFile(path, []byte("v1"), 0o600)
+	c.Assert(err, IsNil)
+
+	registry := secrets.NewRegistry()
+	registry.Register("file", secrets.NewFileBackend(dir))
+	notifier := &fakeNotifier{restarted: make(chan string, 1)}
+	watcher := secrets.NewWatcher(registry, notifier)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ref := secrets.Ref{Backend: "file", Name: "db-password"}
+	err = watcher.Watch(ctx, "web", ref, secrets.OnChange{Action: "restart"})
+	c.Assert(err, IsNil)
+
+	// Touch the file with a new mtime and content so the poller notices.
+	time.Sleep(10 * time.Millisecond)
+	err = os.WriteFile(path, []byte("v2"), 0o600)
+	c.Assert(err, IsNil)
+	future := time.Now().Add(time.Hour)
+	c.Assert(os.Chtimes(path, future, future), IsNil)
+
+	select {
+	case name := <-notifier.restarted:
+		c.Assert(name, Equals, "web")
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for restart notification")
+	}
+}
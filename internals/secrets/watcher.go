@@ -0,0 +1,103 @@
+// Copyright (c) 2021 Canonical Ltd
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// OnChange controls what happens to a service when one of its referenced
+// secrets changes, as set by the service's "on-secret-change" plan field.
+type OnChange struct {
+	// Action is "restart", "signal" or "ignore".
+	Action string
+	// Signal is set when Action is "signal", e.g. "HUP".
+	Signal string
+}
+
+// ParseOnChange parses the "on-secret-change" plan field, which is either
+// "restart", "ignore", or "signal:<name>" (e.g. "signal:HUP").
+func ParseOnChange(s string) (OnChange, error) {
+	if s == "" {
+		s = "restart"
+	}
+	if action, signal, ok := strings.Cut(s, ":"); ok {
+		if action != "signal" {
+			return OnChange{}, fmt.Errorf(`invalid on-secret-change %q: only "signal" takes an argument`, s)
+		}
+		if signal == "" {
+			return OnChange{}, fmt.Errorf(`invalid on-secret-change %q: signal name must be set`, s)
+		}
+		return OnChange{Action: "signal", Signal: signal}, nil
+	}
+	switch s {
+	case "restart", "ignore":
+		return OnChange{Action: s}, nil
+	default:
+		return OnChange{}, fmt.Errorf("invalid on-secret-change %q", s)
+	}
+}
+
+// Notifier is implemented by whatever owns a service's child process; the
+// service manager satisfies it so that Watcher can trigger a restart or
+// signal without needing to know how services are actually supervised.
+type Notifier interface {
+	RestartService(serviceName string)
+	SignalService(serviceName, signal string) error
+}
+
+// Watcher starts a goroutine per watched Ref that calls back into a
+// Notifier whenever the Ref's underlying secret changes.
+type Watcher struct {
+	registry *Registry
+	notifier Notifier
+}
+
+// NewWatcher returns a Watcher that resolves secrets via registry and
+// notifies notifier of changes.
+func NewWatcher(registry *Registry, notifier Notifier) *Watcher {
+	return &Watcher{registry: registry, notifier: notifier}
+}
+
+// Watch starts watching ref for changes and applies onChange to
+// serviceName whenever it changes. The watch runs until ctx is cancelled,
+// which callers should tie to the service's own lifetime.
+func (w *Watcher) Watch(ctx context.Context, serviceName string, ref Ref, onChange OnChange) error {
+	if onChange.Action == "ignore" {
+		return nil
+	}
+	backend, err := w.registry.Backend(ref.Backend)
+	if err != nil {
+		return err
+	}
+	changes, err := backend.Watch(ctx, ref.Name)
+	if err != nil {
+		return fmt.Errorf("cannot watch secret %s: %w", ref, err)
+	}
+
+	go func() {
+		for range changes {
+			switch onChange.Action {
+			case "restart":
+				w.notifier.RestartService(serviceName)
+			case "signal":
+				_ = w.notifier.SignalService(serviceName, onChange.Signal)
+			}
+		}
+	}()
+	return nil
+}